@@ -12,7 +12,7 @@ import (
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("Commands: pull, build, run, images")
+		fmt.Println("Commands: pull, build, run, exec, images, save, load, ps, stop, rm")
 		os.Exit(1)
 	}
 
@@ -51,6 +51,8 @@ func main() {
 		runCmd := flag.NewFlagSet("run", flag.ExitOnError)
 		vols := runCmd.String("v", "", "volume mounts host:cont;host2:cont2")
 		ports := runCmd.String("p", "", "ports host:cont;")
+		mem := runCmd.String("m", "", "memory limit, e.g. 256m")
+		cpus := runCmd.Float64("cpus", 0, "cpu limit in cores, e.g. 0.5")
 		runCmd.Parse(os.Args[2:])
 
 		if len(runCmd.Args()) < 1 {
@@ -60,7 +62,18 @@ func main() {
 		image := runCmd.Args()[0]
 		cmd := runCmd.Args()[1:]
 
-		if err := run.RunContainer(image, cmd, *vols, *ports); err != nil {
+		if err := run.RunContainer(image, cmd, *vols, *ports, run.Limits{Memory: *mem, CPUs: *cpus}); err != nil {
+			panic(err)
+		}
+
+	case "exec":
+		execCmd := flag.NewFlagSet("exec", flag.ExitOnError)
+		execCmd.Parse(os.Args[2:])
+		if len(execCmd.Args()) < 1 {
+			fmt.Println("usage: toy-docker exec <cid> [cmd...]")
+			os.Exit(1)
+		}
+		if err := run.ExecContainer(execCmd.Args()[0], execCmd.Args()[1:]); err != nil {
 			panic(err)
 		}
 
@@ -69,6 +82,61 @@ func main() {
 			panic(err)
 		}
 
+	case "save":
+		saveCmd := flag.NewFlagSet("save", flag.ExitOnError)
+		out := saveCmd.String("o", "", "output archive path")
+		saveCmd.Parse(os.Args[2:])
+		if len(saveCmd.Args()) < 1 {
+			fmt.Println("usage: toy-docker save <image> -o <out.tar>")
+			os.Exit(1)
+		}
+		image := saveCmd.Args()[0]
+		// allow flags after the image name
+		saveCmd.Parse(saveCmd.Args()[1:])
+		if *out == "" || len(saveCmd.Args()) != 0 {
+			fmt.Println("usage: toy-docker save <image> -o <out.tar>")
+			os.Exit(1)
+		}
+		if err := build.SaveImage(image, *out); err != nil {
+			panic(err)
+		}
+
+	case "load":
+		loadCmd := flag.NewFlagSet("load", flag.ExitOnError)
+		in := loadCmd.String("i", "", "input archive path")
+		force := loadCmd.Bool("force", false, "replace an existing image")
+		loadCmd.Parse(os.Args[2:])
+		if *in == "" {
+			fmt.Println("usage: toy-docker load -i <in.tar> [--force]")
+			os.Exit(1)
+		}
+		if err := build.LoadImage(*in, *force); err != nil {
+			panic(err)
+		}
+
+	case "ps":
+		if err := run.ListContainers(); err != nil {
+			panic(err)
+		}
+
+	case "stop":
+		if len(os.Args) != 3 {
+			fmt.Println("usage: toy-docker stop <cid>")
+			os.Exit(1)
+		}
+		if err := run.StopContainer(os.Args[2]); err != nil {
+			panic(err)
+		}
+
+	case "rm":
+		if len(os.Args) != 3 {
+			fmt.Println("usage: toy-docker rm <cid>")
+			os.Exit(1)
+		}
+		if err := run.RemoveContainer(os.Args[2]); err != nil {
+			panic(err)
+		}
+
 	default:
 		fmt.Println("unknown command:", os.Args[1])
 		os.Exit(1)
@@ -4,14 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/creotiv/toy-docker/internal/exec"
 )
 
 type Meta struct {
-	Name   string `json:"name"`
-	Parent string `json:"parent"`
+	Name    string   `json:"name"`
+	Parent  string   `json:"parent"`
+	Env     []string `json:"env,omitempty"`
+	Workdir string   `json:"workdir,omitempty"`
+	Cmd     []string `json:"cmd,omitempty"`
 }
 
 const imagesDir = "images"
@@ -34,21 +39,55 @@ func BuildImage(dockerfile, name string) error {
 	// unpack base image
 	exec.MustRun("[fs] unpack base image", "tar", "-C", tmp, "-xf", parentDir+"/layer.tar")
 
-	// apply RUN/COPY
+	// ENV/WORKDIR/CMD are inherited from the parent, like in Docker
+	meta := Meta{Name: name, Parent: parent}
+	if b, err := os.ReadFile(parentDir + "/meta.json"); err == nil {
+		var pm Meta
+		if err := json.Unmarshal(b, &pm); err != nil {
+			return fmt.Errorf("decode parent meta: %w", err)
+		}
+		meta.Env, meta.Workdir, meta.Cmd = pm.Env, pm.Workdir, pm.Cmd
+	}
+
+	// apply RUN/COPY/ENV/WORKDIR/CMD
 	for _, c := range cmds {
 		if strings.HasPrefix(c, "RUN ") {
 			script := strings.TrimPrefix(c, "RUN ")
 			fmt.Println(">>> RUN", script)
 			// This is how old Docker originally worked
 			// It run command against temporary rootfs
-			exec.MustRun("[fs] run command inside container", "systemd-nspawn", "-D", tmp, "/bin/bash", "-c", script)
+			args := []string{"-D", tmp}
+			for _, kv := range meta.Env {
+				args = append(args, "--setenv="+kv)
+			}
+			if meta.Workdir != "" {
+				args = append(args, "--chdir="+meta.Workdir)
+			}
+			if argv, ok := parseExecForm(script); ok {
+				args = append(args, argv...)
+			} else {
+				args = append(args, "/bin/bash", "-c", script)
+			}
+			exec.MustRun("[fs] run command inside container", "systemd-nspawn", args...)
+		}
+		if strings.HasPrefix(c, "ENV ") {
+			meta.Env = setEnv(meta.Env, strings.TrimPrefix(c, "ENV "))
+		}
+		if strings.HasPrefix(c, "WORKDIR ") {
+			dir := resolveWorkdir(meta.Workdir, strings.TrimPrefix(c, "WORKDIR "))
+			meta.Workdir = dir
+			exec.MustRun("[fs] mkdir workdir", "mkdir", "-p", tmp+dir)
+		}
+		if strings.HasPrefix(c, "CMD ") {
+			meta.Cmd = nil
+			json.Unmarshal([]byte(strings.TrimPrefix(c, "CMD ")), &meta.Cmd)
 		}
 		if strings.HasPrefix(c, "COPY ") {
-			parts := strings.SplitN(strings.TrimPrefix(c, "COPY "), " ", 2)
-			src := parts[0]
-			dst := parts[1]
-			exec.MustRun("[fs] mkdir", "mkdir", "-p", tmp+"/"+dst)
-			exec.MustRun("[fs] copy file", "cp", "-r", src, tmp+"/"+dst)
+			var args []string
+			json.Unmarshal([]byte(strings.TrimPrefix(c, "COPY ")), &args)
+			if err := copyFiles(tmp, meta.Workdir, args[:len(args)-1], args[len(args)-1]); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -61,7 +100,6 @@ func BuildImage(dockerfile, name string) error {
 	}
 	exec.MustRun("[fs] pack new layer", "tar", "-C", tmp, "-cf", outDir+"/layer.tar", ".")
 
-	meta := Meta{Name: name, Parent: parent}
 	m, _ := json.MarshalIndent(meta, "", "  ")
 	if err := os.WriteFile(outDir+"/meta.json", m, 0644); err != nil {
 		return fmt.Errorf("write meta: %w", err)
@@ -74,7 +112,8 @@ func BuildImage(dockerfile, name string) error {
 func ListImages() error {
 	ents, _ := os.ReadDir(imagesDir)
 	for _, e := range ents {
-		if !e.IsDir() {
+		// skip non-images such as the .blobs layer cache
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
 			continue
 		}
 		metaPath := imagesDir + "/" + e.Name() + "/meta.json"
@@ -106,7 +145,32 @@ func parseDockerfile(path string) (string, []string, error) {
 		case strings.HasPrefix(l, "RUN "):
 			cmds = append(cmds, "RUN "+strings.TrimPrefix(l, "RUN "))
 		case strings.HasPrefix(l, "COPY "):
-			cmds = append(cmds, "COPY "+strings.TrimPrefix(l, "COPY "))
+			args, err := splitFields(strings.TrimPrefix(l, "COPY "))
+			if err != nil {
+				return "", nil, fmt.Errorf("COPY: %w", err)
+			}
+			if len(args) < 2 {
+				return "", nil, fmt.Errorf("COPY needs a source and a destination: %s", l)
+			}
+			b, _ := json.Marshal(args)
+			cmds = append(cmds, "COPY "+string(b))
+		case strings.HasPrefix(l, "ENV "):
+			pairs, err := parseEnv(strings.TrimSpace(strings.TrimPrefix(l, "ENV ")))
+			if err != nil {
+				return "", nil, err
+			}
+			for _, kv := range pairs {
+				cmds = append(cmds, "ENV "+kv)
+			}
+		case strings.HasPrefix(l, "WORKDIR "):
+			cmds = append(cmds, "WORKDIR "+strings.TrimSpace(strings.TrimPrefix(l, "WORKDIR ")))
+		case strings.HasPrefix(l, "CMD "):
+			cmd, err := parseCmd(strings.TrimSpace(strings.TrimPrefix(l, "CMD ")))
+			if err != nil {
+				return "", nil, err
+			}
+			b, _ := json.Marshal(cmd)
+			cmds = append(cmds, "CMD "+string(b))
 		default:
 			return "", nil, fmt.Errorf("unknown instruction: %s", l)
 		}
@@ -116,3 +180,148 @@ func parseDockerfile(path string) (string, []string, error) {
 	}
 	return parent, cmds, nil
 }
+
+// parseEnv accepts both `ENV key=value [key2=value2...]` and the legacy
+// `ENV key value` form and returns normalized key=value pairs.
+func parseEnv(s string) ([]string, error) {
+	fields, err := splitFields(s)
+	if err != nil {
+		return nil, fmt.Errorf("ENV: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("ENV requires arguments")
+	}
+	if !strings.Contains(fields[0], "=") {
+		// legacy form: everything after the key is the value, unquoted the
+		// same way as in the key=value form
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("ENV %s: missing value", s)
+		}
+		return []string{fields[0] + "=" + strings.Join(fields[1:], " ")}, nil
+	}
+	var pairs []string
+	for _, f := range fields {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid ENV pair: %s", f)
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	return pairs, nil
+}
+
+// parseExecForm decodes the JSON exec form of RUN (`RUN ["apt-get", "update"]`).
+func parseExecForm(s string) ([]string, bool) {
+	var argv []string
+	if !strings.HasPrefix(s, "[") || json.Unmarshal([]byte(s), &argv) != nil || len(argv) == 0 {
+		return nil, false
+	}
+	return argv, true
+}
+
+// parseCmd handles the exec form (`CMD ["bin", "arg"]`) and the shell form,
+// which Docker wraps in `/bin/sh -c`.
+func parseCmd(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(s), &cmd); err != nil {
+			return nil, fmt.Errorf("invalid CMD %s: %w", s, err)
+		}
+		return cmd, nil
+	}
+	return []string{"/bin/sh", "-c", s}, nil
+}
+
+// resolveWorkdir resolves a relative WORKDIR against the previous one.
+func resolveWorkdir(prev, dir string) string {
+	if path.IsAbs(dir) {
+		return path.Clean(dir)
+	}
+	return path.Join("/", prev, dir)
+}
+
+// setEnv replaces an existing key in env or appends the new pair.
+func setEnv(env []string, kv string) []string {
+	k, _, _ := strings.Cut(kv, "=")
+	out := make([]string, 0, len(env)+1)
+	for _, e := range env {
+		if !strings.HasPrefix(e, k+"=") {
+			out = append(out, e)
+		}
+	}
+	return append(out, kv)
+}
+
+// splitFields splits on whitespace like strings.Fields, but keeps
+// double-quoted runs together (`"my file.txt"`).
+func splitFields(s string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField, inQuote := false, false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"':
+			inQuote = !inQuote
+			inField = true
+		case (ch == ' ' || ch == '\t') && !inQuote:
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteByte(ch)
+			inField = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// copyFiles implements COPY with Docker's destination rules: a dst ending in
+// "/" or naming an existing directory receives the sources inside it,
+// otherwise dst is the target path itself. Relative dst is resolved against
+// WORKDIR. Directory sources contribute their contents, not themselves.
+func copyFiles(rootfs, workdir string, srcs []string, dst string) error {
+	dirDst := strings.HasSuffix(dst, "/")
+	if !path.IsAbs(dst) {
+		dst = path.Join("/", workdir, dst)
+	}
+	target := rootfs + path.Clean(dst)
+	if st, err := os.Stat(target); err == nil && st.IsDir() {
+		dirDst = true
+	}
+	if len(srcs) > 1 && !dirDst {
+		return fmt.Errorf("COPY with multiple sources needs a directory destination ending in /: %s", dst)
+	}
+
+	if dirDst {
+		exec.MustRun("[fs] mkdir", "mkdir", "-p", target)
+	} else {
+		exec.MustRun("[fs] mkdir", "mkdir", "-p", path.Dir(target))
+	}
+
+	for _, src := range srcs {
+		st, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("COPY source: %w", err)
+		}
+		switch {
+		case st.IsDir():
+			// trailing /. copies the directory contents rather than the directory
+			exec.MustRun("[fs] mkdir", "mkdir", "-p", target)
+			exec.MustRun("[fs] copy dir", "cp", "-r", src+"/.", target)
+		case dirDst:
+			exec.MustRun("[fs] copy file", "cp", src, filepath.Join(target, filepath.Base(src)))
+		default:
+			exec.MustRun("[fs] copy file", "cp", src, target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	cases := map[string][]string{
+		`src dst`:                      {"src", "dst"},
+		`"my file.txt" "/app/my dir/"`: {"my file.txt", "/app/my dir/"},
+		`a.txt  b.txt	c.txt /dest/`:    {"a.txt", "b.txt", "c.txt", "/dest/"},
+		`dir/"part two"/x /d/`:         {"dir/part two/x", "/d/"},
+		`C:\path\file /d/`:             {`C:\path\file`, "/d/"},
+		`"" /d/`:                       {"", "/d/"},
+	}
+	for in, want := range cases {
+		got, err := splitFields(in)
+		if err != nil {
+			t.Errorf("splitFields(%q): %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("splitFields(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if _, err := splitFields(`"unterminated /dst`); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestParseDockerfileCopy(t *testing.T) {
+	df := filepath.Join(t.TempDir(), "Dockerfile")
+	content := "FROM base\nCOPY \"my file.txt\" other.txt /app/\n"
+	if err := os.WriteFile(df, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parent, cmds, err := parseDockerfile(df)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent != "base" {
+		t.Fatalf("parent = %q", parent)
+	}
+	want := []string{`COPY ["my file.txt","other.txt","/app/"]`}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("cmds = %q, want %q", cmds, want)
+	}
+}
+
+// copyFixture returns an empty rootfs and a host file named "my file.txt".
+func copyFixture(t *testing.T) (rootfs, src string) {
+	t.Helper()
+	dir := t.TempDir()
+	rootfs = filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	src = filepath.Join(dir, "my file.txt")
+	if err := os.WriteFile(src, []byte("payload"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return rootfs, src
+}
+
+func assertFile(t *testing.T, path string) {
+	t.Helper()
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if st.IsDir() {
+		t.Fatalf("%s is a directory, want a file", path)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "payload" {
+		t.Fatalf("%s = %q", path, b)
+	}
+}
+
+func TestCopyFileToFile(t *testing.T) {
+	rootfs, src := copyFixture(t)
+	if err := copyFiles(rootfs, "", []string{src}, "/app/config.json"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "app", "config.json"))
+}
+
+func TestCopyFileIntoDir(t *testing.T) {
+	rootfs, src := copyFixture(t)
+	if err := copyFiles(rootfs, "", []string{src}, "/app/"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "app", "my file.txt"))
+}
+
+func TestCopyIntoExistingDir(t *testing.T) {
+	rootfs, src := copyFixture(t)
+	os.MkdirAll(filepath.Join(rootfs, "etc", "app"), 0755)
+	if err := copyFiles(rootfs, "", []string{src}, "/etc/app"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "etc", "app", "my file.txt"))
+}
+
+func TestCopyMultipleSourcesNeedsDir(t *testing.T) {
+	rootfs, src := copyFixture(t)
+	err := copyFiles(rootfs, "", []string{src, src}, "/app/config.json")
+	if err == nil || !strings.Contains(err.Error(), "multiple sources") {
+		t.Fatalf("got %v, want multiple sources error", err)
+	}
+}
+
+func TestCopyRelativeDstUsesWorkdir(t *testing.T) {
+	rootfs, src := copyFixture(t)
+	if err := copyFiles(rootfs, "/srv/app", []string{src}, "conf/"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(rootfs, "srv", "app", "conf", "my file.txt"))
+}
+
+func TestParseEnv(t *testing.T) {
+	cases := map[string][]string{
+		`A=1`:                    {"A=1"},
+		`A=1 B=2`:                {"A=1", "B=2"},
+		`MSG="hello world"`:      {"MSG=hello world"},
+		`A=1	B=2`:                {"A=1", "B=2"},
+		`MSG hello`:              {"MSG=hello"},
+		`MSG "hello world"`:      {"MSG=hello world"},
+		`MSG hello world`:        {"MSG=hello world"},
+		"K\tv":                   {"K=v"},
+		`EMPTY=`:                 {"EMPTY="},
+		`PATH=/opt/bin:/usr/bin`: {"PATH=/opt/bin:/usr/bin"},
+	}
+	for in, want := range cases {
+		got, err := parseEnv(in)
+		if err != nil {
+			t.Errorf("parseEnv(%q): %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseEnv(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	for _, in := range []string{``, `KEY`, `=value`, `A=1 junk`, `MSG "open`} {
+		if _, err := parseEnv(in); err == nil {
+			t.Errorf("parseEnv(%q) accepted", in)
+		}
+	}
+}
+
+func TestParseCmd(t *testing.T) {
+	cases := map[string][]string{
+		`["nginx", "-g", "daemon off;"]`: {"nginx", "-g", "daemon off;"},
+		`echo hi && sleep 1`:             {"/bin/sh", "-c", "echo hi && sleep 1"},
+	}
+	for in, want := range cases {
+		got, err := parseCmd(in)
+		if err != nil {
+			t.Errorf("parseCmd(%q): %v", in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseCmd(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if _, err := parseCmd(`["unterminated"`); err == nil {
+		t.Error("invalid exec form accepted")
+	}
+}
+
+func TestSetEnv(t *testing.T) {
+	env := []string{"A=1", "AB=2", "B=3"}
+	got := setEnv(env, "A=9")
+	if want := []string{"AB=2", "B=3", "A=9"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("setEnv replace = %q, want %q", got, want)
+	}
+	got = setEnv(env, "C=4")
+	if want := []string{"A=1", "AB=2", "B=3", "C=4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("setEnv append = %q, want %q", got, want)
+	}
+}
+
+func TestResolveWorkdir(t *testing.T) {
+	cases := []struct{ prev, dir, want string }{
+		{"", "/app", "/app"},
+		{"/app", "src", "/app/src"},
+		{"/app/src", "../lib", "/app/lib"},
+		{"", "rel", "/rel"},
+		{"/app", "/other/", "/other"},
+	}
+	for _, tc := range cases {
+		if got := resolveWorkdir(tc.prev, tc.dir); got != tc.want {
+			t.Errorf("resolveWorkdir(%q, %q) = %q, want %q", tc.prev, tc.dir, got, tc.want)
+		}
+	}
+}
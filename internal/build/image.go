@@ -0,0 +1,179 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveManifest sits at the root of a saved image archive, next to the
+// image's layer.tar and meta.json.
+type archiveManifest struct {
+	Name  string `json:"name"`
+	Layer string `json:"layer"`
+	Meta  string `json:"meta"`
+}
+
+const (
+	archiveManifestName = "manifest.json"
+	layerName           = "layer.tar"
+	metaName            = "meta.json"
+)
+
+// SaveImage packs images/<name> into a single tar at out so it can be moved
+// to a machine without registry access.
+func SaveImage(name, out string) error {
+	imgDir := filepath.Join(imagesDir, name)
+	for _, f := range []string{layerName, metaName} {
+		if _, err := os.Stat(filepath.Join(imgDir, f)); err != nil {
+			return fmt.Errorf("image %s: %w", name, err)
+		}
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	man, _ := json.MarshalIndent(archiveManifest{Name: name, Layer: layerName, Meta: metaName}, "", "  ")
+	if err := writeTarEntry(tw, archiveManifestName, int64(len(man)), bytes.NewReader(man)); err != nil {
+		return err
+	}
+	for _, entry := range []string{metaName, layerName} {
+		if err := addTarFile(tw, filepath.Join(imgDir, entry), entry); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("finish archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+
+	fmt.Printf("saved: %s to %s\n", name, out)
+	return nil
+}
+
+// LoadImage unpacks an archive written by SaveImage into images/<name>.
+// An existing image is only replaced when force is set.
+func LoadImage(in string, force bool) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return fmt.Errorf("prepare images dir: %w", err)
+	}
+	tmp, err := os.MkdirTemp(imagesDir, ".load-")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// only the three known entries are extracted; anything else is ignored,
+	// which also keeps hostile paths out of images/
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		switch hdr.Name {
+		case archiveManifestName, layerName, metaName:
+		default:
+			continue
+		}
+		dst, err := os.Create(filepath.Join(tmp, hdr.Name))
+		if err != nil {
+			return fmt.Errorf("create %s: %w", hdr.Name, err)
+		}
+		if _, err := io.Copy(dst, tr); err != nil {
+			dst.Close()
+			return fmt.Errorf("extract %s: %w", hdr.Name, err)
+		}
+		if err := dst.Close(); err != nil {
+			return fmt.Errorf("write %s: %w", hdr.Name, err)
+		}
+	}
+
+	var man archiveManifest
+	if b, err := os.ReadFile(filepath.Join(tmp, archiveManifestName)); err != nil {
+		return fmt.Errorf("archive has no %s", archiveManifestName)
+	} else if err := json.Unmarshal(b, &man); err != nil {
+		return fmt.Errorf("decode %s: %w", archiveManifestName, err)
+	}
+	if man.Name == "" || man.Name != filepath.Base(man.Name) || strings.HasPrefix(man.Name, ".") {
+		return fmt.Errorf("invalid image name in archive: %q", man.Name)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, layerName)); err != nil {
+		return fmt.Errorf("archive has no %s", layerName)
+	}
+	b, err := os.ReadFile(filepath.Join(tmp, metaName))
+	if err != nil {
+		return fmt.Errorf("archive has no %s", metaName)
+	}
+	var meta Meta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return fmt.Errorf("decode %s: %w", metaName, err)
+	}
+	os.Remove(filepath.Join(tmp, archiveManifestName))
+
+	outDir := filepath.Join(imagesDir, man.Name)
+	if _, err := os.Stat(outDir); err == nil {
+		if !force {
+			return fmt.Errorf("image %s already exists (use --force to replace it)", man.Name)
+		}
+		if err := os.RemoveAll(outDir); err != nil {
+			return fmt.Errorf("remove existing image: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("check image dir: %w", err)
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return fmt.Errorf("chmod image dir: %w", err)
+	}
+	if err := os.Rename(tmp, outDir); err != nil {
+		return fmt.Errorf("install image: %w", err)
+	}
+
+	fmt.Println("loaded:", man.Name)
+	return nil
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	return writeTarEntry(tw, name, st.Size(), f)
+}
+
+func writeTarEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write %s header: %w", name, err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package build
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inTempDir runs the test from an empty dir, since imagesDir is relative.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+	return dir
+}
+
+func writeImage(t *testing.T, name, layer, meta string) {
+	t.Helper()
+	dir := filepath.Join(imagesDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, layerName), []byte(layer), 0644)
+	os.WriteFile(filepath.Join(dir, metaName), []byte(meta), 0644)
+}
+
+// writeArchive builds a hand-made archive to feed LoadImage bad input.
+func writeArchive(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for name, body := range entries {
+		if err := writeTarEntry(tw, name, int64(len(body)), strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+const testMeta = `{"name":"app","parent":"ubuntu-22.04","cmd":["/bin/app"]}`
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	dir := inTempDir(t)
+	writeImage(t, "app", "layer-bytes", testMeta)
+	out := filepath.Join(dir, "app.tar")
+
+	if err := SaveImage("app", out); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(imagesDir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadImage(out, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, filepath.Join(imagesDir, "app", layerName)); got != "layer-bytes" {
+		t.Errorf("layer.tar = %q", got)
+	}
+	if got := readFile(t, filepath.Join(imagesDir, "app", metaName)); got != testMeta {
+		t.Errorf("meta.json = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(imagesDir, "app", archiveManifestName)); !os.IsNotExist(err) {
+		t.Errorf("archive manifest left in image dir: %v", err)
+	}
+	// no .load-* temp dirs left behind
+	ents, _ := os.ReadDir(imagesDir)
+	if len(ents) != 1 {
+		t.Errorf("images dir has %d entries, want 1", len(ents))
+	}
+}
+
+func TestLoadImageForce(t *testing.T) {
+	dir := inTempDir(t)
+	writeImage(t, "app", "new-layer", testMeta)
+	out := filepath.Join(dir, "app.tar")
+	if err := SaveImage("app", out); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, "app", "old-layer", testMeta)
+
+	err := LoadImage(out, false)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("got %v, want already exists", err)
+	}
+	if got := readFile(t, filepath.Join(imagesDir, "app", layerName)); got != "old-layer" {
+		t.Fatalf("existing image clobbered without force: %q", got)
+	}
+
+	if err := LoadImage(out, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(imagesDir, "app", layerName)); got != "new-layer" {
+		t.Fatalf("force did not replace image: %q", got)
+	}
+}
+
+func TestLoadImageRejectsBadArchives(t *testing.T) {
+	manifest := func(name string) string {
+		return `{"name":"` + name + `","layer":"layer.tar","meta":"meta.json"}`
+	}
+	cases := map[string]struct {
+		entries map[string]string
+		want    string
+	}{
+		"no layer": {
+			map[string]string{archiveManifestName: manifest("app"), metaName: testMeta},
+			"no layer.tar",
+		},
+		"bad meta": {
+			map[string]string{archiveManifestName: manifest("app"), layerName: "x", metaName: "{not json"},
+			"decode meta.json",
+		},
+		"no manifest": {
+			map[string]string{layerName: "x", metaName: testMeta},
+			"no manifest.json",
+		},
+		"path traversal": {
+			map[string]string{archiveManifestName: manifest("../x"), layerName: "x", metaName: testMeta},
+			"invalid image name",
+		},
+		"hidden dir": {
+			map[string]string{archiveManifestName: manifest(".blobs"), layerName: "x", metaName: testMeta},
+			"invalid image name",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := inTempDir(t)
+			in := filepath.Join(dir, "in.tar")
+			writeArchive(t, in, tc.entries)
+
+			err := LoadImage(in, true)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want error containing %q", err, tc.want)
+			}
+			ents, _ := os.ReadDir(imagesDir)
+			if len(ents) != 0 {
+				t.Fatalf("rejected archive left %d entries in images/", len(ents))
+			}
+			if _, err := os.Stat(filepath.Join(dir, "x")); !os.IsNotExist(err) {
+				t.Fatal("archive escaped images/")
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package pull
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// config mirrors ~/.toy-docker/config.json:
+//
+//	{"auths": {"harbor.example.com": {"username": "...", "password": "..."}}}
+type config struct {
+	Auths map[string]credentials `json:"auths"`
+}
+
+// registryAuth carries whatever we know about talking to one registry.
+// The token is filled in lazily the first time the registry challenges us.
+type registryAuth struct {
+	creds  credentials
+	token  string
+	basic  bool
+	client *http.Client // nil means http.DefaultClient
+}
+
+// loadCredentials looks up credentials for a registry host. A per-host entry
+// in ~/.toy-docker/config.json wins; otherwise the TOY_DOCKER_USERNAME/
+// TOY_DOCKER_PASSWORD env vars are used, but never for Docker Hub, so private
+// registry credentials aren't sent to auth.docker.io on public pulls.
+func loadCredentials(registry string) (credentials, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return credentials{}, err
+	}
+	if c, ok := cfg.Auths[registry]; ok {
+		return c, nil
+	}
+
+	if registry == dockerHubRegistry {
+		return credentials{}, nil
+	}
+	if u := os.Getenv("TOY_DOCKER_USERNAME"); u != "" {
+		return credentials{Username: u, Password: os.Getenv("TOY_DOCKER_PASSWORD")}, nil
+	}
+	return credentials{}, nil
+}
+
+func readConfig() (config, error) {
+	var cfg config
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".toy-docker", "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("decode config: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c credentials) empty() bool {
+	return c.Username == "" && c.Password == ""
+}
+
+func (a *registryAuth) httpClient() *http.Client {
+	if a.client != nil {
+		return a.client
+	}
+	return http.DefaultClient
+}
+
+func (a *registryAuth) apply(req *http.Request) {
+	switch {
+	case a.token != "":
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	case a.basic:
+		req.SetBasicAuth(a.creds.Username, a.creds.Password)
+	}
+}
+
+// do sends the request and, on a 401, answers the registry's challenge once
+// and retries. Bearer challenges are exchanged for a token at the advertised
+// realm; anything else falls back to plain Basic auth.
+func (a *registryAuth) do(req *http.Request) (*http.Response, error) {
+	a.apply(req)
+	resp, err := a.httpClient().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if params, ok := parseBearerChallenge(challenge); ok {
+		token, err := a.requestToken(params)
+		if err != nil {
+			return nil, err
+		}
+		a.token = token
+	} else if !a.creds.empty() {
+		a.basic = true
+	} else {
+		return nil, fmt.Errorf("registry requires authentication; set TOY_DOCKER_USERNAME/TOY_DOCKER_PASSWORD or add it to ~/.toy-docker/config.json")
+	}
+
+	retry := req.Clone(req.Context())
+	a.apply(retry)
+	return a.httpClient().Do(retry)
+}
+
+// parseBearerChallenge parses a header like
+// `Bearer realm="https://auth/token",service="reg",scope="repository:x:pull"`.
+func parseBearerChallenge(h string) (map[string]string, bool) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(h), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end == -1 {
+				kv, rest = after[1:], ""
+			} else {
+				kv, rest = after[1:end+1], after[end+2:]
+			}
+		} else {
+			kv, rest, _ = strings.Cut(after, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = kv
+	}
+
+	if params["realm"] == "" {
+		return nil, false
+	}
+	return params, true
+}
+
+// requestToken exchanges credentials (or nothing, for anonymous pulls)
+// for a registry token at the given realm.
+func (a *registryAuth) requestToken(params map[string]string) (string, error) {
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", fmt.Errorf("parse token realm: %w", err)
+	}
+	q := u.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	if s := params["scope"]; s != "" {
+		q.Set("scope", s)
+	}
+	u.RawQuery = q.Encode()
+
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	if !a.creds.empty() {
+		req.SetBasicAuth(a.creds.Username, a.creds.Password)
+	}
+
+	resp, err := a.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s (%s)", resp.Status, string(body))
+	}
+
+	var t dockerToken
+	if err := json.Unmarshal(body, &t); err != nil {
+		return "", fmt.Errorf("decode token: %w", err)
+	}
+	if t.Token == "" {
+		// some registries only return the OAuth2 field name
+		t.Token = t.AccessToken
+	}
+	return t.Token, nil
+}
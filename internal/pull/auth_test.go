@@ -0,0 +1,140 @@
+package pull
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testImage(srv *httptest.Server) imageRef {
+	return imageRef{Registry: "test", Endpoint: srv.URL, Repository: "team/app", Tag: "1.0"}
+}
+
+func TestFetchManifestBearerChallenge(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			u, p, ok := r.BasicAuth()
+			if !ok || u != "alice" || p != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if got := r.URL.Query().Get("scope"); got != "repository:team/app:pull" {
+				t.Errorf("token scope = %q", got)
+			}
+			fmt.Fprint(w, `{"token":"tok123"}`)
+		case "/v2/team/app/manifests/1.0":
+			if r.Header.Get("Authorization") != "Bearer tok123" {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:team/app:pull"`, srv.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"layers":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	auth := &registryAuth{creds: credentials{Username: "alice", Password: "secret"}, client: srv.Client()}
+	body, _, err := fetchManifest(testImage(srv), auth, "1.0")
+	if err != nil {
+		t.Fatalf("fetchManifest: %v", err)
+	}
+	if string(body) != `{"layers":[]}` {
+		t.Fatalf("body = %s", body)
+	}
+	if auth.token != "tok123" {
+		t.Fatalf("token not kept for later requests: %q", auth.token)
+	}
+}
+
+func TestFetchManifestBasicFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != "alice" || p != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"layers":[]}`)
+	}))
+	defer srv.Close()
+
+	auth := &registryAuth{creds: credentials{Username: "alice", Password: "secret"}, client: srv.Client()}
+	if _, _, err := fetchManifest(testImage(srv), auth, "1.0"); err != nil {
+		t.Fatalf("fetchManifest: %v", err)
+	}
+	if !auth.basic {
+		t.Fatal("expected basic auth to be remembered")
+	}
+}
+
+func TestFetchManifestNoCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	auth := &registryAuth{client: srv.Client()}
+	_, _, err := fetchManifest(testImage(srv), auth, "1.0")
+	if err == nil || !strings.Contains(err.Error(), "requires authentication") {
+		t.Fatalf("got %v, want authentication error", err)
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	params, ok := parseBearerChallenge(`Bearer realm="https://auth.example.com/token",service="reg",scope="repository:a/b:pull,push"`)
+	if !ok {
+		t.Fatal("challenge not recognized")
+	}
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "reg",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("%s = %q, want %q", k, params[k], v)
+		}
+	}
+
+	if _, ok := parseBearerChallenge(`Basic realm="x"`); ok {
+		t.Error("basic challenge parsed as bearer")
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TOY_DOCKER_USERNAME", "envuser")
+	t.Setenv("TOY_DOCKER_PASSWORD", "envpass")
+
+	os.MkdirAll(filepath.Join(home, ".toy-docker"), 0755)
+	cfg := `{"auths": {"harbor.example.com": {"username": "cfguser", "password": "cfgpass"}}}`
+	if err := os.WriteFile(filepath.Join(home, ".toy-docker", "config.json"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]credentials{
+		"harbor.example.com": {Username: "cfguser", Password: "cfgpass"},
+		"other.example.com":  {Username: "envuser", Password: "envpass"},
+		dockerHubRegistry:    {},
+	}
+	for registry, want := range cases {
+		got, err := loadCredentials(registry)
+		if err != nil {
+			t.Fatalf("%s: %v", registry, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", registry, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package pull
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchLayerUsesBlobCache(t *testing.T) {
+	t.Setenv("TOY_DOCKER_CACHE", t.TempDir())
+
+	blob := gzipped(t, testLayer(t))
+	digest := digestOf(blob)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/v2/team/app/blobs/"+digest {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(blob)
+	}))
+	defer srv.Close()
+
+	img := testImage(srv)
+	auth := &registryAuth{client: srv.Client()}
+
+	for i, want := range []int32{1, 1} {
+		dst := t.TempDir()
+		if err := fetchLayer(img, auth, digest, dst); err != nil {
+			t.Fatalf("fetchLayer #%d: %v", i+1, err)
+		}
+		if got := requests.Load(); got != want {
+			t.Fatalf("after fetchLayer #%d: %d requests, want %d", i+1, got, want)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "hello")); err != nil {
+			t.Fatalf("fetchLayer #%d did not extract: %v", i+1, err)
+		}
+	}
+}
+
+func TestFetchLayerRejectsBadDownload(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("TOY_DOCKER_CACHE", cache)
+
+	blob := gzipped(t, testLayer(t))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(blob[:len(blob)/2])
+	}))
+	defer srv.Close()
+
+	if err := fetchLayer(testImage(srv), &registryAuth{client: srv.Client()}, digestOf(blob), t.TempDir()); err == nil {
+		t.Fatal("truncated download accepted")
+	}
+	if ents, _ := os.ReadDir(cache); len(ents) != 0 {
+		t.Fatalf("cache kept %d entries after a bad download", len(ents))
+	}
+}
@@ -2,8 +2,11 @@ package pull
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,7 +28,8 @@ const (
 )
 
 type dockerToken struct {
-	Token string `json:"token"`
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
 }
 
 type manifestList struct {
@@ -47,13 +51,16 @@ type manifest struct {
 
 type imageRef struct {
 	Registry    string
+	Endpoint    string // base URL of the registry API, e.g. https://registry-1.docker.io
 	Repository  string
 	Tag         string
 	DisplayName string
 }
 
 // PullImage downloads an image (any repository on Docker Hub) and flattens its
-// layers into a single layer.tar inside images/<image>.
+// layers into a single layer.tar inside images/<image>. Credentials for
+// private registries come from ~/.toy-docker/config.json keyed by registry
+// host, or TOY_DOCKER_USERNAME/TOY_DOCKER_PASSWORD (see loadCredentials).
 func PullImage(ref string) error {
 	img, err := parseRef(ref)
 	if err != nil {
@@ -71,12 +78,17 @@ func PullImage(ref string) error {
 		return nil
 	}
 
-	token, err := fetchToken(img)
+	creds, err := loadCredentials(img.Registry)
 	if err != nil {
 		return err
 	}
 
-	manBody, ctype, err := fetchManifest(img, token, img.Tag)
+	auth := &registryAuth{creds: creds}
+	if auth.token, err = fetchToken(img, auth); err != nil {
+		return err
+	}
+
+	manBody, ctype, err := fetchManifest(img, auth, img.Tag)
 	if err != nil {
 		return err
 	}
@@ -86,7 +98,7 @@ func PullImage(ref string) error {
 		if err != nil {
 			return err
 		}
-		manBody, ctype, err = fetchManifest(img, token, digest)
+		manBody, ctype, err = fetchManifest(img, auth, digest)
 		if err != nil {
 			return err
 		}
@@ -109,7 +121,7 @@ func PullImage(ref string) error {
 	}
 
 	for i, l := range imgManifest.Layers {
-		if err := fetchLayer(img, token, l.Digest, rootfs); err != nil {
+		if err := fetchLayer(img, auth, l.Digest, rootfs); err != nil {
 			return fmt.Errorf("layer %d (%s): %w", i, l.Digest, err)
 		}
 	}
@@ -162,6 +174,7 @@ func parseRef(ref string) (imageRef, error) {
 
 	return imageRef{
 		Registry:    registry,
+		Endpoint:    "https://" + registry,
 		Repository:  repo,
 		Tag:         tag,
 		DisplayName: name,
@@ -210,39 +223,24 @@ func looksLikeVersionSuffix(s string) bool {
 	return true
 }
 
-func fetchToken(img imageRef) (string, error) {
-	// Docker Hub supports anonymous token fetch; other registries may not need it.
+func fetchToken(img imageRef, auth *registryAuth) (string, error) {
+	// Docker Hub supports anonymous token fetch; other registries tell us
+	// where to get a token via the WWW-Authenticate challenge on first 401.
 	if img.Registry != dockerHubRegistry {
 		return "", nil
 	}
 
-	url := fmt.Sprintf("%s?service=registry.docker.io&scope=repository:%s:pull", dockerHubAuth, img.Repository)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("fetch token: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token request failed: %s (%s)", resp.Status, string(body))
-	}
-
-	var t dockerToken
-	if err := json.Unmarshal(body, &t); err != nil {
-		return "", fmt.Errorf("decode token: %w", err)
-	}
-
-	return t.Token, nil
+	return auth.requestToken(map[string]string{
+		"realm":   dockerHubAuth,
+		"service": "registry.docker.io",
+		"scope":   "repository:" + img.Repository + ":pull",
+	})
 }
 
-func fetchManifest(img imageRef, token string, reference string) ([]byte, string, error) {
-	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", img.Registry, img.Repository, reference)
+func fetchManifest(img imageRef, auth *registryAuth, reference string) ([]byte, string, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", img.Endpoint, img.Repository, reference)
 
 	req, _ := http.NewRequest("GET", url, nil)
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
-	}
 	req.Header.Set("Accept", strings.Join([]string{
 		"application/vnd.docker.distribution.manifest.v2+json",
 		"application/vnd.oci.image.manifest.v1+json",
@@ -250,7 +248,7 @@ func fetchManifest(img imageRef, token string, reference string) ([]byte, string
 		"application/vnd.oci.image.index.v1+json",
 	}, ", "))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := auth.do(req)
 	if err != nil {
 		return nil, "", fmt.Errorf("fetch manifest: %w", err)
 	}
@@ -306,14 +304,57 @@ func parseImageManifest(body []byte) (manifest, error) {
 	return m, nil
 }
 
-func fetchLayer(img imageRef, token, digest, dest string) error {
-	url := fmt.Sprintf("https://%s/v2/%s/blobs/%s", img.Registry, img.Repository, digest)
-	req, _ := http.NewRequest("GET", url, nil)
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
+// blobCacheDir is the content-addressed store for downloaded layer blobs,
+// shared by all images so common base layers are fetched only once.
+func blobCacheDir() string {
+	if v := os.Getenv("TOY_DOCKER_CACHE"); v != "" {
+		return v
+	}
+	return filepath.Join(imagesDir, ".blobs")
+}
+
+func digestHex(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || sum == "" || strings.ContainsAny(sum, "/.") {
+		return "", fmt.Errorf("unsupported digest: %s", digest)
+	}
+	return sum, nil
+}
+
+func fetchLayer(img imageRef, auth *registryAuth, digest, dest string) error {
+	sum, err := digestHex(digest)
+	if err != nil {
+		return err
+	}
+
+	cached := filepath.Join(blobCacheDir(), sum)
+	if _, err := os.Stat(cached); err == nil {
+		fmt.Println("using cached layer:", digest)
+	} else if err := downloadBlob(img, auth, digest, cached); err != nil {
+		return err
+	}
+
+	f, err := os.Open(cached)
+	if err != nil {
+		return fmt.Errorf("open cached blob: %w", err)
+	}
+	defer f.Close()
+
+	if err := extractVerified(dest, digest, f); err != nil {
+		// don't keep serving a blob we couldn't extract; next pull refetches it
+		os.Remove(cached)
+		return err
 	}
+	return nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// downloadBlob fetches a blob into the cache, verifying its digest before it
+// becomes visible under its final name.
+func downloadBlob(img imageRef, auth *registryAuth, digest, target string) error {
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", img.Endpoint, img.Repository, digest)
+	req, _ := http.NewRequest("GET", url, nil)
+
+	resp, err := auth.do(req)
 	if err != nil {
 		return fmt.Errorf("fetch blob: %w", err)
 	}
@@ -324,17 +365,72 @@ func fetchLayer(img imageRef, token, digest, dest string) error {
 		return fmt.Errorf("blob request failed: %s (%s)", resp.Status, string(body))
 	}
 
-	return extractLayer(dest, resp.Body)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("prepare blob cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "download-")
+	if err != nil {
+		return fmt.Errorf("create blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return fmt.Errorf("download blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+
+	want := filepath.Base(target)
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("layer digest mismatch: want %s got %s", want, got)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("store blob: %w", err)
+	}
+	return nil
+}
+
+// extractVerified extracts a layer blob while hashing the raw bytes as served
+// by the registry, then checks them against the manifest digest.
+func extractVerified(dst, digest string, r io.Reader) error {
+	want, err := digestHex(digest)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	if err := extractLayer(dst, tee); err != nil {
+		return err
+	}
+	// tar stops at its end marker; hash whatever padding/trailer is left
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return fmt.Errorf("read blob: %w", err)
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("layer digest mismatch: want %s got %s", want, got)
+	}
+	return nil
 }
 
 func extractLayer(dst string, r io.Reader) error {
-	gr, err := gzip.NewReader(r)
+	// peek at the magic bytes so an uncompressed layer isn't partially
+	// consumed by a failed gzip header read
+	br := bufio.NewReader(r)
 	var tr *tar.Reader
-	if err == nil {
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("open gzip layer: %w", err)
+		}
 		defer gr.Close()
 		tr = tar.NewReader(gr)
 	} else {
-		tr = tar.NewReader(r)
+		tr = tar.NewReader(br)
 	}
 
 	for {
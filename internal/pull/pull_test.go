@@ -0,0 +1,80 @@
+package pull
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testLayer returns an uncompressed tar holding a single file "hello".
+func testLayer(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	body := []byte("hi there")
+	if err := tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(body)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(b)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestExtractVerified(t *testing.T) {
+	plain := testLayer(t)
+	gz := gzipped(t, plain)
+	cases := map[string]struct {
+		blob []byte
+		flip int // byte to corrupt; chosen so extraction itself still succeeds
+	}{
+		// gzip trailer (ISIZE): tar stops before it, only the hash sees it
+		"gzip": {gz, len(gz) - 1},
+		// first byte of the file body, right after the 512-byte header
+		"uncompressed": {plain, 512},
+	}
+
+	for name, tc := range cases {
+		blob := tc.blob
+		t.Run(name, func(t *testing.T) {
+			dst := t.TempDir()
+			if err := extractVerified(dst, digestOf(blob), bytes.NewReader(blob)); err != nil {
+				t.Fatalf("extract good blob: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dst, "hello"))
+			if err != nil || string(got) != "hi there" {
+				t.Fatalf("extracted file = %q, %v", got, err)
+			}
+
+			corrupt := append([]byte(nil), blob...)
+			corrupt[tc.flip] ^= 0xff
+			err = extractVerified(t.TempDir(), digestOf(blob), bytes.NewReader(corrupt))
+			if err == nil || !strings.Contains(err.Error(), "layer digest mismatch") {
+				t.Fatalf("corrupt blob: got %v, want layer digest mismatch", err)
+			}
+		})
+	}
+}
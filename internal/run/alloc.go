@@ -0,0 +1,72 @@
+package run
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// newContainerID returns 12 random hex chars, like a short Docker id.
+func newContainerID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate container id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// allocateIP picks the lowest host address in netRange that is not the
+// bridge gateway and not in inUse.
+func allocateIP(inUse map[string]bool) (string, error) {
+	prefix := netip.MustParsePrefix(netRange)
+	gateway := netip.MustParsePrefix(bridgeCIDR).Addr()
+
+	// skip the network address; stop before the broadcast address
+	for a := prefix.Addr().Next(); prefix.Contains(a.Next()); a = a.Next() {
+		if a == gateway || inUse[a.String()] {
+			continue
+		}
+		return a.String(), nil
+	}
+	return "", fmt.Errorf("no free addresses left in %s", netRange)
+}
+
+// holdsIP reports whether the record still owns its address: either the
+// container is running, or the run that created it hasn't started it yet.
+func (c *Container) holdsIP() bool {
+	return c.Running() || c.Starting()
+}
+
+// reserveIP assigns rec a free IP and persists it. The store is locked for
+// the scan+write so concurrent runs can't pick the same address.
+func reserveIP(rec *Container) error {
+	lock, err := os.OpenFile(filepath.Join(containersDir(), ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open store lock: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock store: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	cs, err := listContainers()
+	if err != nil {
+		return err
+	}
+	inUse := map[string]bool{}
+	for _, c := range cs {
+		if c.ID != rec.ID && c.holdsIP() {
+			inUse[c.IP] = true
+		}
+	}
+
+	if rec.IP, err = allocateIP(inUse); err != nil {
+		return err
+	}
+	return saveContainer(rec)
+}
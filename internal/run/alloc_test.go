@@ -0,0 +1,61 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAllocateIPPicksLowestFree(t *testing.T) {
+	inUse := map[string]bool{
+		"10.200.0.2": true,
+		"10.200.0.3": true,
+		"10.200.0.5": true,
+	}
+	ip, err := allocateIP(inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "10.200.0.4" {
+		t.Fatalf("allocateIP = %s, want 10.200.0.4", ip)
+	}
+}
+
+func TestAllocateIPSkipsGateway(t *testing.T) {
+	ip, err := allocateIP(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "10.200.0.2" {
+		t.Fatalf("allocateIP = %s, want 10.200.0.2 (.1 is the bridge)", ip)
+	}
+}
+
+func TestAllocateIPExhausted(t *testing.T) {
+	inUse := map[string]bool{}
+	for i := 2; i <= 254; i++ {
+		inUse[fmt.Sprintf("10.200.0.%d", i)] = true
+	}
+	if _, err := allocateIP(inUse); err == nil || !strings.Contains(err.Error(), "no free addresses") {
+		t.Fatalf("got %v, want exhaustion error", err)
+	}
+}
+
+func TestNewContainerID(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := newContainerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !re.MatchString(id) {
+			t.Fatalf("id %q is not 12 hex chars", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate id %q", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,122 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	cgroupName = "toy-docker"
+	cpuPeriod  = 100000
+	// the kernel rejects cpu.max quotas below 1ms
+	cpuMinQuota = 1000
+)
+
+// Limits are the resource caps requested on the run command line.
+// Zero values mean "unlimited".
+type Limits struct {
+	Memory string  // e.g. "256m", "1g", or plain bytes
+	CPUs   float64 // fractional cores, e.g. 0.5
+}
+
+func (l Limits) empty() bool {
+	return l.Memory == "" && l.CPUs == 0
+}
+
+// parseMemory turns "256m"-style sizes into bytes.
+func parseMemory(limit string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(limit))
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory limit: %q", limit)
+	}
+	return n * mult, nil
+}
+
+// cpuQuota converts fractional cores into a cpu.max quota for cpuPeriod.
+func cpuQuota(cpus float64) (int64, error) {
+	quota := int64(cpus * cpuPeriod)
+	if cpus < 0 || quota < cpuMinQuota {
+		return 0, fmt.Errorf("invalid cpu limit %v: must be at least %v", cpus, float64(cpuMinQuota)/cpuPeriod)
+	}
+	return quota, nil
+}
+
+// createCgroup makes /sys/fs/cgroup/toy-docker/<cid> and writes the limits.
+// The caller starts the container process inside it via CgroupFD.
+func createCgroup(cid string, l Limits) (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("resource limits need cgroup v2 mounted at %s", cgroupRoot)
+	}
+
+	var mem, quota int64
+	var err error
+	if l.Memory != "" {
+		if mem, err = parseMemory(l.Memory); err != nil {
+			return "", err
+		}
+	}
+	if l.CPUs != 0 {
+		if quota, err = cpuQuota(l.CPUs); err != nil {
+			return "", err
+		}
+	}
+
+	parent := filepath.Join(cgroupRoot, cgroupName)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("create cgroup %s: %w", parent, err)
+	}
+	// controllers have to be delegated to children before their files show up
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644); err != nil {
+		return "", fmt.Errorf("enable cgroup controllers: %w", err)
+	}
+
+	dir := filepath.Join(parent, cid)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("create cgroup %s: %w", dir, err)
+	}
+
+	if mem > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(mem, 10)), 0644); err != nil {
+			return dir, fmt.Errorf("set memory.max: %w", err)
+		}
+	}
+	if quota > 0 {
+		val := fmt.Sprintf("%d %d", quota, cpuPeriod)
+		if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(val), 0644); err != nil {
+			return dir, fmt.Errorf("set cpu.max: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// removeCgroup deletes the cgroup dir. rmdir fails with EBUSY until the last
+// process has left, so give a just-killed container a moment to die.
+func removeCgroup(dir string) {
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = os.Remove(dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Println("[cleanup] remove cgroup:", err)
+}
@@ -0,0 +1,37 @@
+package run
+
+import "testing"
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{
+		"256m": 256 << 20,
+		"1G":   1 << 30,
+		"512k": 512 << 10,
+		"4096": 4096,
+	}
+	for in, want := range cases {
+		got, err := parseMemory(in)
+		if err != nil || got != want {
+			t.Errorf("parseMemory(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "m", "-1m", "12x"} {
+		if _, err := parseMemory(in); err == nil {
+			t.Errorf("parseMemory(%q) accepted", in)
+		}
+	}
+}
+
+func TestCPUQuota(t *testing.T) {
+	if q, err := cpuQuota(0.5); err != nil || q != 50000 {
+		t.Errorf("cpuQuota(0.5) = %d, %v; want 50000", q, err)
+	}
+	if q, err := cpuQuota(0.01); err != nil || q != cpuMinQuota {
+		t.Errorf("cpuQuota(0.01) = %d, %v; want %d", q, err, cpuMinQuota)
+	}
+	for _, cpus := range []float64{0.001, -1} {
+		if _, err := cpuQuota(cpus); err == nil {
+			t.Errorf("cpuQuota(%v) accepted", cpus)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/creotiv/toy-docker/internal/exec"
+)
+
+// teardown collects the undo steps for everything RunContainer sets up on the
+// host. Steps run once, in reverse order, either when RunContainer returns or
+// when the parent is interrupted. Once torn down, steps added later (setup
+// that was still in flight when the signal came) run immediately.
+type teardown struct {
+	mu          sync.Mutex
+	steps       []func()
+	done        bool
+	interrupted bool
+}
+
+func (t *teardown) add(step func()) {
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		step()
+		return
+	}
+	defer t.mu.Unlock()
+	t.steps = append(t.steps, step)
+}
+
+func (t *teardown) run() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	t.done = true
+	fmt.Println("[run] cleaning up container")
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		t.steps[i]()
+	}
+}
+
+func (t *teardown) wasInterrupted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interrupted
+}
+
+// onInterrupt tears the container down when the parent receives SIGINT or
+// SIGTERM. We don't exit from the signal goroutine: RunContainer keeps going
+// so anything it's halfway through creating is undone by add, and
+// exitIfInterrupted ends the process once it returns. A second signal exits
+// right away. The returned func stops listening.
+func (t *teardown) onInterrupt() func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			fmt.Println("[run] got", sig)
+			t.mu.Lock()
+			t.interrupted = true
+			t.mu.Unlock()
+			t.run()
+		case <-stop:
+			return
+		}
+		select {
+		case <-sigs:
+			os.Exit(1)
+		case <-stop:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(stop)
+	}
+}
+
+// exitIfInterrupted exits with a failure status after an interrupt, instead
+// of handing RunContainer's fallout error back to the caller.
+func (t *teardown) exitIfInterrupted() {
+	if t.wasInterrupted() {
+		os.Exit(1)
+	}
+}
+
+// warnOnErr runs a cleanup command and only reports failures; by the time we
+// clean up, some resources (e.g. the veth) may already be gone with the netns.
+func warnOnErr(desc, name string, args ...string) {
+	if out, err := exec.RunOut(desc, name, args...); err != nil {
+		fmt.Printf("    warning: %v %s\n", err, out)
+	}
+}
@@ -0,0 +1,29 @@
+package run
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTeardownRunsInReverseOnce(t *testing.T) {
+	var got []int
+	td := &teardown{}
+	td.add(func() { got = append(got, 1) })
+	td.add(func() { got = append(got, 2) })
+	td.run()
+	td.run()
+	if want := []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("steps ran as %v, want %v", got, want)
+	}
+}
+
+func TestTeardownAddAfterRunCleansUpImmediately(t *testing.T) {
+	td := &teardown{}
+	td.run()
+
+	ran := false
+	td.add(func() { ran = true })
+	if !ran {
+		t.Fatal("step added after teardown was never run")
+	}
+}
@@ -0,0 +1,48 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	stdexec "os/exec"
+	"strconv"
+)
+
+// ExecContainer runs cmd inside a running container's namespaces via nsenter,
+// defaulting to an interactive bash.
+func ExecContainer(cid string, cmd []string) error {
+	c, err := loadContainer(cid)
+	if err != nil {
+		return err
+	}
+	if !c.Running() {
+		return fmt.Errorf("container %s is not running (pid %d is gone)", cid, c.Pid)
+	}
+	if len(cmd) == 0 {
+		cmd = []string{"/bin/bash"}
+	}
+
+	args := []string{"--target", strconv.Itoa(c.Pid), "--mount", "--uts", "--ipc", "--net", "--pid"}
+	if c.Workdir != "" {
+		args = append(args, "--wd="+c.Workdir)
+	}
+	args = append(args, cmd...)
+
+	e := stdexec.Command("nsenter", args...)
+	e.Stdout = os.Stdout
+	e.Stderr = os.Stderr
+	e.Stdin = os.Stdin
+	e.Env = execEnv(c.Env, os.Getenv("TERM"))
+	return e.Run()
+}
+
+// execEnv builds the environment for an exec'd process from the container's
+// image ENV plus a default PATH and the caller's TERM, without leaking the
+// rest of the host environment (SUDO_*, host PATH, ...).
+func execEnv(imageEnv []string, term string) []string {
+	env := []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+	if term != "" {
+		env = append(env, "TERM="+term)
+	}
+	// later entries win in exec, so image values override the defaults
+	return append(env, imageEnv...)
+}
@@ -0,0 +1,19 @@
+package run
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExecEnv(t *testing.T) {
+	got := execEnv([]string{"PATH=/opt/bin", "APP=1"}, "xterm")
+	want := []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"TERM=xterm",
+		"PATH=/opt/bin",
+		"APP=1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("execEnv = %q, want %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package run
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -17,6 +18,8 @@ func Init() {
 	veth := os.Getenv("VETH")
 	vols := os.Getenv("VOLUMES")
 	cmd := os.Getenv("CMD")
+	imgEnv := os.Getenv("IMAGE_ENV")
+	workdir := os.Getenv("WORKDIR")
 
 	fmt.Println("[run] Initing the container --------------------------------")
 	fmt.Println("rootfs = ", rootfs)
@@ -25,6 +28,8 @@ func Init() {
 	fmt.Println("veth = ", veth)
 	fmt.Println("vols = ", vols)
 	fmt.Println("cmd = ", cmd)
+	fmt.Println("env = ", imgEnv)
+	fmt.Println("workdir = ", workdir)
 	fmt.Println("------------------------------------------------------------")
 
 	// expose netns
@@ -93,6 +98,23 @@ func Init() {
 	fmt.Println(out)
 	fmt.Println("PID 1 = ", os.Getpid())
 
+	// export image ENV into the container process environment
+	var env []string
+	if imgEnv != "" {
+		if err := json.Unmarshal([]byte(imgEnv), &env); err != nil {
+			panic(fmt.Errorf("decode image env: %w", err))
+		}
+	}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		os.Setenv(k, v)
+	}
+	if workdir != "" {
+		if err := os.Chdir(workdir); err != nil {
+			panic(fmt.Errorf("chdir to workdir: %w", err))
+		}
+	}
+
 	// replace PID 1 with the container command
 	exec.MustRun("[fs] exec cmd", "/bin/bash", "-c", "exec "+cmd)
 
@@ -3,11 +3,15 @@ package run
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	stdexec "os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/creotiv/toy-docker/internal/exec"
@@ -21,8 +25,11 @@ const (
 )
 
 type Meta struct {
-	Name   string `json:"name"`
-	Parent string `json:"parent"`
+	Name    string   `json:"name"`
+	Parent  string   `json:"parent"`
+	Env     []string `json:"env,omitempty"`
+	Workdir string   `json:"workdir,omitempty"`
+	Cmd     []string `json:"cmd,omitempty"`
 }
 
 func ensureBridge() error {
@@ -64,7 +71,7 @@ func ensureBridge() error {
 	return nil
 }
 
-func RunContainer(image string, cmd []string, volumes string, ports string) error {
+func RunContainer(image string, cmd []string, volumes string, ports string, limits Limits) error {
 	if err := ensureBridge(); err != nil {
 		return err
 	}
@@ -77,7 +84,23 @@ func RunContainer(image string, cmd []string, volumes string, ports string) erro
 	imgDir := imagesDir + "/" + image
 	layer := imgDir + "/layer.tar"
 
-	cid := fmt.Sprintf("%d-%d", os.Getpid(), os.Getpid()+12345)
+	meta, err := readMeta(imgDir)
+	if err != nil {
+		return err
+	}
+	// a command on the CLI always wins over the image CMD
+	if len(cmd) == 0 {
+		if len(meta.Cmd) == 0 {
+			return fmt.Errorf("no command given and image %s has no CMD", image)
+		}
+		cmd = shellQuote(meta.Cmd)
+	}
+	imgEnv, _ := json.Marshal(meta.Env)
+
+	cid, err := newContainerID()
+	if err != nil {
+		return err
+	}
 	rootfs := filepath.Join(contDir, cid, "rootfs")
 	if err := os.MkdirAll(rootfs, 0755); err != nil {
 		return fmt.Errorf("create rootfs: %w", err)
@@ -91,16 +114,43 @@ func RunContainer(image string, cmd []string, volumes string, ports string) erro
 		return err
 	}
 
-	// Allocate IP
-	ip := fmt.Sprintf("10.200.0.%d", 10+os.Getpid()%200)
+	// interface names are capped at 15 chars
+	vethH := "vethh" + cid[:10]
+	vethC := "vethc" + cid[:10]
+	nsfile := "/var/run/toy-" + cid + ".ns"
 
-	vethH := "vethh" + cid[:6]
-	vethC := "vethc" + cid[:6]
+	td := &teardown{}
+	defer td.exitIfInterrupted()
+	defer td.run()
+	defer td.onInterrupt()()
+
+	// Allocate IP; the record is written now so other runs see the address as taken
+	rec := &Container{
+		ID:       cid,
+		Image:    image,
+		VethHost: vethH,
+		VethCont: vethC,
+		NSFile:   nsfile,
+		Started:  time.Now(),
+		Owner:    os.Getpid(),
+		// snapshot the image config so exec doesn't depend on images/ later
+		Env:     meta.Env,
+		Workdir: meta.Workdir,
+	}
+	if err := reserveIP(rec); err != nil {
+		return err
+	}
+	live := &liveRecord{c: rec}
+	td.add(func() {
+		live.update(func(rc *Container) { rc.Exited = true })
+	})
+	ip := rec.IP
 
 	// Create veth pair
 	if err := exec.RunOrErr("[net] create veth", "ip", "link", "add", vethH, "type", "veth", "peer", "name", vethC); err != nil {
 		return err
 	}
+	td.add(func() { warnOnErr("[net] delete veth", "ip", "link", "del", vethH) })
 
 	// Put host side into bridge
 	if err := exec.RunOrErr("[net] attach veth to bridge", "ip", "link", "set", vethH, "master", bridgeName); err != nil {
@@ -111,10 +161,16 @@ func RunContainer(image string, cmd []string, volumes string, ports string) erro
 	}
 
 	// Prepare netns file (Linux trick)
-	nsfile := "/var/run/toy-" + cid + ".ns"
 	if err := os.WriteFile(nsfile, []byte{}, 0644); err != nil {
 		return fmt.Errorf("create ns file: %w", err)
 	}
+	td.add(func() {
+		// init bind-mounts its netns here; the mount may have propagated to the host
+		stdexec.Command("umount", nsfile).Run()
+		if err := os.Remove(nsfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("[cleanup] remove ns file:", err)
+		}
+	})
 
 	// Prepare container-init MustRun
 	self, err := os.Executable()
@@ -145,13 +201,49 @@ func RunContainer(image string, cmd []string, volumes string, ports string) erro
 		"VETH="+vethC,
 		"VOLUMES="+volumes,
 		"CMD="+strings.Join(cmd, " "),
+		"IMAGE_ENV="+string(imgEnv),
+		"WORKDIR="+meta.Workdir,
 	)
 
+	var cgroup string
+	if !limits.empty() {
+		cgroup, err = createCgroup(cid, limits)
+		if cgroup != "" {
+			td.add(func() { removeCgroup(cgroup) })
+		}
+		if err != nil {
+			return err
+		}
+		// Start the child directly inside the cgroup (clone3 CLONE_INTO_CGROUP),
+		// so init and everything it forks are capped from the first instruction.
+		cgfd, err := os.Open(cgroup)
+		if err != nil {
+			return fmt.Errorf("open cgroup: %w", err)
+		}
+		defer cgfd.Close()
+		c.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgfd.Fd())}
+	}
+
+	if td.wasInterrupted() {
+		return errors.New("interrupted before container start")
+	}
+
 	fmt.Println("[run] starting container namespace")
 
 	if err := c.Start(); err != nil {
 		return fmt.Errorf("container init failed: %w", err)
 	}
+	var exited atomic.Bool
+	td.add(func() {
+		// only when we bail out (error or signal) before the container finished
+		if exited.Load() {
+			return
+		}
+		if pid := live.pid(); pid > 0 {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		c.Process.Kill()
+	})
 
 	// Wait for the child to finish unsharing its network namespace before moving the veth
 	// inside. Without this, we sometimes race and move the veth into the host netns because
@@ -166,12 +258,51 @@ func RunContainer(image string, cmd []string, volumes string, ports string) erro
 		return err
 	}
 
+	// Record the init pid so ps/stop can find the container
+	ipid, err := initPid(c.Process.Pid)
+	if err != nil {
+		return err
+	}
+	if err := live.update(func(rc *Container) { rc.Pid = ipid }); err != nil {
+		return err
+	}
+
 	// Port forwarding
-	if err := configurePorts(ip, ports); err != nil {
+	rules, err := configurePorts(ip, ports)
+	for _, r := range rules {
+		td.add(func() {
+			warnOnErr("[net] remove port forward", "iptables", append([]string{"-t", "nat", "-D"}, r...)...)
+		})
+	}
+	if err != nil {
 		return err
 	}
 
-	return c.Wait()
+	err = c.Wait()
+	exited.Store(true)
+	return err
+}
+
+func readMeta(imgDir string) (Meta, error) {
+	var m Meta
+	b, err := os.ReadFile(imgDir + "/meta.json")
+	if err != nil {
+		return m, fmt.Errorf("read image meta: %w", err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("decode image meta: %w", err)
+	}
+	return m, nil
+}
+
+// shellQuote wraps each argument in single quotes so an exec-form CMD
+// survives being passed to `bash -c` by Init.
+func shellQuote(args []string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return out
 }
 
 func containersDir() string {
@@ -183,26 +314,32 @@ func containersDir() string {
 	return filepath.Join(os.TempDir(), "toy-docker", "containers")
 }
 
-func configurePorts(ip, ports string) error {
+// configurePorts adds a DNAT rule per host:cont mapping and returns the rules
+// it managed to add (chain and match args, without -A), so the caller can
+// delete exactly those with -D even if a later mapping fails.
+func configurePorts(ip, ports string) ([][]string, error) {
 	if ports == "" {
-		return nil
+		return nil, nil
 	}
+	var rules [][]string
 	for _, p := range strings.Split(ports, ";") {
 		if p == "" {
 			continue
 		}
 		parts := strings.Split(p, ":")
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid port mapping: %s", p)
+			return rules, fmt.Errorf("invalid port mapping: %s", p)
 		}
 		hp, cp := parts[0], parts[1]
-		if err := exec.RunOrErr("[net] add port forward", "iptables", "-t", "nat", "-A", "PREROUTING",
+		rule := []string{"PREROUTING",
 			"-p", "tcp", "--dport", hp,
-			"-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%s", ip, cp)); err != nil {
-			return err
+			"-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%s", ip, cp)}
+		if err := exec.RunOrErr("[net] add port forward", "iptables", append([]string{"-t", "nat", "-A"}, rule...)...); err != nil {
+			return rules, err
 		}
+		rules = append(rules, rule)
 	}
-	return nil
+	return rules, nil
 }
 
 // waitForChildNetns blocks until the child process has switched to a new netns.
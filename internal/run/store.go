@@ -0,0 +1,214 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Container is the on-disk record of a container, stored next to its rootfs
+// in containers/<cid>/container.json.
+type Container struct {
+	ID       string    `json:"id"`
+	Image    string    `json:"image"`
+	Pid      int       `json:"pid"`
+	IP       string    `json:"ip"`
+	VethHost string    `json:"veth_host"`
+	VethCont string    `json:"veth_container"`
+	NSFile   string    `json:"nsfile"`
+	Started  time.Time `json:"started"`
+	Exited   bool      `json:"exited"`
+	Owner    int       `json:"owner"` // pid of the toy-docker run that created it
+	Env      []string  `json:"env,omitempty"`
+	Workdir  string    `json:"workdir,omitempty"`
+}
+
+func containerDir(cid string) string {
+	return filepath.Join(containersDir(), cid)
+}
+
+func recordPath(cid string) string {
+	return filepath.Join(containerDir(cid), "container.json")
+}
+
+// saveContainer writes the record via a temp file and rename, so readers
+// like ps never see a half-written container.json.
+func saveContainer(c *Container) error {
+	b, _ := json.MarshalIndent(c, "", "  ")
+	path := recordPath(c.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("write container record: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write container record: %w", err)
+	}
+	return nil
+}
+
+// liveRecord guards the record RunContainer keeps updating while teardown
+// steps, possibly on the signal goroutine, read and save it too.
+type liveRecord struct {
+	mu sync.Mutex
+	c  *Container
+}
+
+// update applies f and persists the result under the lock.
+func (r *liveRecord) update(f func(c *Container)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(r.c)
+	return saveContainer(r.c)
+}
+
+func (r *liveRecord) pid() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.c.Pid
+}
+
+func loadContainer(cid string) (*Container, error) {
+	b, err := os.ReadFile(recordPath(cid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no such container: %s", cid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read container record: %w", err)
+	}
+	var c Container
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("decode container record %s: %w", cid, err)
+	}
+	return &c, nil
+}
+
+func listContainers() ([]*Container, error) {
+	ents, err := os.ReadDir(containersDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read containers dir: %w", err)
+	}
+	var out []*Container
+	for _, e := range ents {
+		if !e.IsDir() {
+			continue
+		}
+		c, err := loadContainer(e.Name())
+		if err != nil {
+			// rootfs without a record (e.g. from an older run); nothing to show
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Running reports whether the container's init process is still alive.
+// Records left behind by a crashed parent still say running, so we probe the pid.
+func (c *Container) Running() bool {
+	if c.Exited || c.Pid <= 0 {
+		return false
+	}
+	return syscall.Kill(c.Pid, 0) == nil
+}
+
+// Starting reports whether the record was written but the container hasn't
+// been started yet, and the run that is setting it up is still alive.
+func (c *Container) Starting() bool {
+	if c.Exited || c.Pid > 0 {
+		return false
+	}
+	return c.Owner > 0 && syscall.Kill(c.Owner, 0) == nil
+}
+
+func (c *Container) status() string {
+	switch {
+	case c.Running():
+		return "Up " + time.Since(c.Started).Round(time.Second).String()
+	case c.Starting():
+		return "Starting"
+	}
+	return "Exited"
+}
+
+// ListContainers prints all known containers as a table.
+func ListContainers() error {
+	cs, err := listContainers()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tPID\tIP\tCREATED\tSTATUS")
+	for _, c := range cs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			c.ID, c.Image, c.Pid, c.IP, c.Started.Format(time.DateTime), c.status())
+	}
+	return w.Flush()
+}
+
+// StopContainer sends SIGTERM to the container's init process.
+func StopContainer(cid string) error {
+	c, err := loadContainer(cid)
+	if err != nil {
+		return err
+	}
+	if !c.Running() {
+		return fmt.Errorf("container %s is not running", cid)
+	}
+	if err := syscall.Kill(c.Pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("stop container %s: %w", cid, err)
+	}
+	fmt.Println("stopped:", cid)
+	return nil
+}
+
+// RemoveContainer deletes a stopped container's rootfs, netns file and record.
+func RemoveContainer(cid string) error {
+	c, err := loadContainer(cid)
+	if err != nil {
+		return err
+	}
+	if c.Running() {
+		return fmt.Errorf("container %s is running; stop it first", cid)
+	}
+	if c.Starting() {
+		return fmt.Errorf("container %s is still starting", cid)
+	}
+	if c.NSFile != "" {
+		if err := os.Remove(c.NSFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove ns file: %w", err)
+		}
+	}
+	if err := os.RemoveAll(containerDir(cid)); err != nil {
+		return fmt.Errorf("remove container dir: %w", err)
+	}
+	fmt.Println("removed:", cid)
+	return nil
+}
+
+// initPid finds the process unshare forked into the new pid namespace.
+// That child is the container's init; unshare itself stays in the host pid ns.
+func initPid(unsharePid int) (int, error) {
+	path := fmt.Sprintf("/proc/%d/task/%d/children", unsharePid, unsharePid)
+	for i := 0; i < 50; i++ {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			if fields := strings.Fields(string(b)); len(fields) > 0 {
+				return strconv.Atoi(fields[0])
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0, fmt.Errorf("timed out waiting for init process of pid %d", unsharePid)
+}
@@ -0,0 +1,152 @@
+package run
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deadPid returns the pid of a process that has already been reaped.
+func deadPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot spawn a helper process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func newStore(t *testing.T) {
+	t.Helper()
+	t.Setenv("TOY_DOCKER_CONTAINERS", t.TempDir())
+}
+
+func saveTestContainer(t *testing.T, c *Container) {
+	t.Helper()
+	if err := os.MkdirAll(containerDir(c.ID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveContainer(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerRecordRoundTrip(t *testing.T) {
+	newStore(t)
+	want := &Container{
+		ID:      "abc123def456",
+		Image:   "ubuntu-22.04",
+		Pid:     42,
+		IP:      "10.200.0.2",
+		Started: time.Now().Round(time.Second),
+		Env:     []string{"A=1"},
+		Workdir: "/app",
+	}
+	saveTestContainer(t, want)
+
+	got, err := loadContainer(want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != want.ID || got.Image != want.Image || got.Pid != want.Pid || got.IP != want.IP ||
+		!got.Started.Equal(want.Started) || got.Workdir != want.Workdir || len(got.Env) != 1 {
+		t.Fatalf("loaded %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadContainerMissing(t *testing.T) {
+	newStore(t)
+	_, err := loadContainer("nope")
+	if err == nil || !strings.Contains(err.Error(), "no such container") {
+		t.Fatalf("got %v, want no such container", err)
+	}
+}
+
+func TestListContainersSkipsDirsWithoutRecord(t *testing.T) {
+	newStore(t)
+	saveTestContainer(t, &Container{ID: "withrecord"})
+	if err := os.MkdirAll(filepath.Join(containersDir(), "orphan", "rootfs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := listContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 || cs[0].ID != "withrecord" {
+		t.Fatalf("listContainers = %+v, want only withrecord", cs)
+	}
+}
+
+func TestContainerStatus(t *testing.T) {
+	dead := deadPid(t)
+	cases := []struct {
+		name string
+		c    Container
+		want string
+	}{
+		{"dead pid", Container{Pid: dead}, "Exited"},
+		{"marked exited", Container{Pid: os.Getpid(), Exited: true}, "Exited"},
+		{"running", Container{Pid: os.Getpid()}, "Up"},
+		{"starting", Container{Owner: os.Getpid()}, "Starting"},
+		{"owner died before start", Container{Owner: dead}, "Exited"},
+	}
+	for _, tc := range cases {
+		if got := tc.c.status(); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%s: status = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRemoveContainer(t *testing.T) {
+	newStore(t)
+	saveTestContainer(t, &Container{ID: "starting", Owner: os.Getpid()})
+	saveTestContainer(t, &Container{ID: "stopped", Pid: deadPid(t)})
+
+	if err := RemoveContainer("starting"); err == nil {
+		t.Fatal("removed a container that is still being set up")
+	}
+	if _, err := loadContainer("starting"); err != nil {
+		t.Fatalf("starting container record gone: %v", err)
+	}
+
+	if err := RemoveContainer("stopped"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(containerDir("stopped")); !os.IsNotExist(err) {
+		t.Fatalf("container dir still there: %v", err)
+	}
+}
+
+func TestLiveRecordConcurrentUpdates(t *testing.T) {
+	newStore(t)
+	rec := &Container{ID: "live"}
+	saveTestContainer(t, rec)
+	live := &liveRecord{c: rec}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			live.update(func(c *Container) { c.Exited = true })
+			live.pid()
+		}
+	}()
+	for i := 1; i <= 50; i++ {
+		if err := live.update(func(c *Container) { c.Pid = i }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	got, err := loadContainer("live")
+	if err != nil {
+		t.Fatalf("record unreadable after concurrent saves: %v", err)
+	}
+	if got.Pid != 50 || !got.Exited {
+		t.Fatalf("record = %+v, want pid 50 and exited", got)
+	}
+}